@@ -6,13 +6,54 @@ import (
 	"time"
 )
 
+// CancelFunc cancels a FlatContext produced by WithCancel, WithDeadline, or
+// WithTimeout. Calling it more than once is a no-op.
+type CancelFunc = context.CancelFunc
+
+// CancelCauseFunc cancels a FlatContext produced by WithCancelCause, recording
+// cause as the reason. Calling it more than once is a no-op; only the first
+// call's cause is recorded. Calling it with a nil cause sets the cause to
+// context.Canceled.
+type CancelCauseFunc func(cause error)
+
 // FlatContext is a flattened implementation of context.Context.
 // It stores key-value pairs in a map and delegates cancellation, timeout,
-// and other context-related operations to its parent context.
+// and other context-related operations to its parent context, unless it
+// owns its own cancellation signal (see WithCancel, WithDeadline, WithTimeout).
 type FlatContext struct {
-	parent context.Context             // The parent context to delegate operations to
-	values map[interface{}]interface{} // A map to store key-value pairs
-	mu     sync.RWMutex                // A mutex to ensure thread-safe access to the values map
+	parent  context.Context             // The parent context to delegate operations to
+	parents []context.Context           // Set by Merge/WithParents; when non-empty, takes precedence over parent for Value/Deadline
+	values  map[interface{}]interface{} // A map to store key-value pairs
+	mu      sync.RWMutex                // A mutex to ensure thread-safe access to the values map and cancellation state
+
+	// Native cancellation state. done is nil unless this FlatContext was
+	// created via WithCancel/WithCancelCause/WithDeadline/WithTimeout or
+	// Merge, or has had WithParents called on it, in which case Done/Err are
+	// served locally instead of delegating to parent.
+	done        chan struct{}
+	err         error
+	cause       error
+	timer       *time.Timer
+	deadline    time.Time
+	hasDeadline bool
+
+	// mergeGen and mergeGenDone let WithParents retire the fan-in goroutines
+	// watching a previous parent set: each call bumps mergeGen and closes
+	// the old mergeGenDone, waking goroutines watching stale parents so
+	// they exit instead of being able to cancel c on a parent's behalf.
+	mergeGen     uint64
+	mergeGenDone chan struct{}
+
+	// AfterFunc bookkeeping, guarded by afterMu (kept separate from mu so
+	// that registering/stopping callbacks never contends with value or
+	// cancellation access).
+	afterMu    sync.Mutex
+	afterFuncs map[uint64]func()
+	afterSeq   uint64
+	watching   bool
+	swapSignal chan struct{} // closed and replaced by WithContext, WithParents, and stop to wake the watcher
+
+	frozen bool // set by Freeze; WithValue panics once true
 }
 
 // New creates a new flattened context.
@@ -35,42 +76,223 @@ func Background() *FlatContext {
 }
 
 // WithContext replaces the parent context of the current FlatContext with the provided context.
-// This allows dynamically changing the parent context.
+// This allows dynamically changing the parent context, and is mutually exclusive with Merge/
+// WithParents: it clears any parent set previously installed and retires the fan-in goroutines
+// that were watching it, so a parent set via WithParents can no longer affect c once WithContext
+// has been called. If c owns its cancellation signal (see Done), Done and Err keep reporting
+// that signal after the swap; the new parent's cancellation does not reach them.
 func (c *FlatContext) WithContext(ctx context.Context) {
+	c.mu.Lock()
 	c.parent = ctx
+	c.parents = nil
+	staleGenDone := c.mergeGenDone
+	if staleGenDone != nil {
+		c.mergeGen++
+		c.mergeGenDone = nil
+	}
+	c.mu.Unlock()
+	if staleGenDone != nil {
+		close(staleGenDone)
+	}
+
+	c.afterMu.Lock()
+	if c.swapSignal != nil {
+		close(c.swapSignal)
+		c.swapSignal = make(chan struct{})
+	}
+	c.afterMu.Unlock()
+}
+
+// Merge returns a FlatContext that is done as soon as any of parents is
+// done, whose Err (and Cause) come from whichever parent finished first, and
+// whose Deadline is the earliest deadline among parents. Value(key) scans
+// the local map, then each parent in order.
+//
+// This fills the gap left by the standard library, which deliberately does
+// not provide a way to join independent cancellation sources (see
+// https://pkg.go.dev/context#WithCancel), and is useful for combining a
+// request-scoped context with a server-shutdown context.
+func Merge(parents ...context.Context) *FlatContext {
+	m := &FlatContext{
+		parent:       context.Background(),
+		values:       make(map[interface{}]interface{}),
+		parents:      parents,
+		done:         make(chan struct{}),
+		mergeGenDone: make(chan struct{}),
+	}
+	for _, p := range parents {
+		m.propagateMergeCancel(p, m.mergeGen, m.mergeGenDone)
+	}
+	return m
+}
+
+// WithParents turns c into a merge of parents, per Merge: Done fires when
+// any parent finishes, Err/Cause come from whichever parent finished first,
+// and Value/Deadline consult parents in order. It replaces any parent set
+// via WithContext or a prior WithParents call.
+//
+// Calling WithParents again retires the fan-in goroutines watching the
+// previous parent set, so a parent that has since been detached can no
+// longer cancel c.
+func (c *FlatContext) WithParents(parents ...context.Context) {
+	c.mu.Lock()
+	c.parents = parents
+	if c.parent == nil {
+		// Guard against an empty parent list leaving Value/Deadline's
+		// len(c.parents) == 0 fallback path holding a nil interface.
+		c.parent = context.Background()
+	}
+	if c.done == nil {
+		c.done = make(chan struct{})
+	}
+	staleGenDone := c.mergeGenDone
+	c.mergeGen++
+	gen := c.mergeGen
+	c.mergeGenDone = make(chan struct{})
+	genDone := c.mergeGenDone
+	c.mu.Unlock()
+
+	if staleGenDone != nil {
+		close(staleGenDone)
+	}
+	for _, p := range parents {
+		c.propagateMergeCancel(p, gen, genDone)
+	}
+
+	c.afterMu.Lock()
+	if c.swapSignal != nil {
+		close(c.swapSignal)
+		c.swapSignal = make(chan struct{})
+	}
+	c.afterMu.Unlock()
+}
+
+// propagateMergeCancel is propagateCancel's counterpart for Merge/WithParents:
+// it cancels child when parent finishes, but only as long as parent is still
+// part of child's current parent set (gen). If WithParents replaces the
+// parent set, genDone is closed and the goroutine watching parent retires
+// without canceling child.
+func (child *FlatContext) propagateMergeCancel(parent context.Context, gen uint64, genDone <-chan struct{}) {
+	parentDone := parent.Done()
+	if parentDone == nil {
+		return
+	}
+	select {
+	case <-parentDone:
+		child.cancel(causeOf(parent), parent.Err())
+		return
+	default:
+	}
+	go func() {
+		select {
+		case <-parentDone:
+			child.mu.RLock()
+			stale := child.mergeGen != gen
+			child.mu.RUnlock()
+			if stale {
+				return
+			}
+			child.cancel(causeOf(parent), parent.Err())
+		case <-genDone:
+		case <-child.done:
+		}
+	}()
+}
+
+// valueOnlyContext strips cancellation and deadline from a context.Context,
+// keeping only Value. It is the same trick the standard library's
+// context.WithoutCancel uses internally.
+type valueOnlyContext struct {
+	context.Context
+}
+
+func (valueOnlyContext) Deadline() (deadline time.Time, ok bool) { return }
+func (valueOnlyContext) Done() <-chan struct{}                   { return nil }
+func (valueOnlyContext) Err() error                              { return nil }
+
+// Detach returns a new FlatContext that preserves every value reachable from
+// c (its own local values and everything reachable through its parent
+// chain) but whose Done returns nil, Deadline reports no deadline, and Err
+// returns nil. It is the flat-context analogue of Go 1.21's
+// context.WithoutCancel, for background work (metrics flushes, async
+// logging, cache warmups) that a request kicks off but that must outlive
+// the request's own cancellation.
+func Detach(c *FlatContext) *FlatContext {
+	return &FlatContext{
+		parent: valueOnlyContext{c},
+		values: make(map[interface{}]interface{}),
+	}
 }
 
 // Deadline implements the context.Context interface.
-// It delegates the call to the parent context's Deadline method.
-// It returns the deadline time (if set) and a boolean indicating whether a deadline is set.
+// If this FlatContext owns a deadline (set via WithDeadline or WithTimeout),
+// it is returned directly. Otherwise, if it was created via Merge or
+// WithParents, the earliest deadline among its parents is returned; failing
+// that, the call is delegated to the parent context's Deadline method.
 func (c *FlatContext) Deadline() (deadline time.Time, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.hasDeadline {
+		return c.deadline, true
+	}
+	if len(c.parents) > 0 {
+		for _, p := range c.parents {
+			if d, pok := p.Deadline(); pok && (!ok || d.Before(deadline)) {
+				deadline, ok = d, true
+			}
+		}
+		return deadline, ok
+	}
 	return c.parent.Deadline()
 }
 
 // Done implements the context.Context interface.
-// It delegates the call to the parent context's Done method.
-// It returns a channel that is closed when the context is canceled or times out.
+// If this FlatContext owns its cancellation signal (set via WithCancel,
+// WithCancelCause, WithDeadline, WithTimeout, Merge, or WithParents), its own
+// channel is returned, even after WithContext has swapped the parent;
+// otherwise the call is delegated to the parent context's Done method.
 func (c *FlatContext) Done() <-chan struct{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.done != nil {
+		return c.done
+	}
 	return c.parent.Done()
 }
 
 // Err implements the context.Context interface.
-// It delegates the call to the parent context's Err method.
-// It returns an error indicating why the context was canceled or timed out.
+// If this FlatContext owns its cancellation signal, its own error is
+// returned; otherwise the call is delegated to the parent context's Err
+// method.
 func (c *FlatContext) Err() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.done != nil {
+		return c.err
+	}
 	return c.parent.Err()
 }
 
 // Value implements the context.Context interface.
 // It first checks if the key exists in the current context's values map.
 // If it does, it returns the corresponding value.
-// If not, it tries to retrieve the value from the parent context.
+// If not, and this FlatContext was created via Merge or WithParents, each
+// parent is scanned in order; otherwise the lookup is delegated to the
+// single parent context.
 func (c *FlatContext) Value(key interface{}) interface{} {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	if value, ok := c.values[key]; ok {
 		return value
 	}
+	if len(c.parents) > 0 {
+		for _, p := range c.parents {
+			if value := p.Value(key); value != nil {
+				return value
+			}
+		}
+		return nil
+	}
 	// If not found in the current context, look for it in the parent context.
 	return c.parent.Value(key)
 }
@@ -79,10 +301,341 @@ func (c *FlatContext) Value(key interface{}) interface{} {
 // It locks the mutex, adds the new key-value pair to the current context's values map,
 // and then returns the current context instance.
 // Note: This method modifies the current context in place and returns the same instance.
-// If immutability is required, consider creating a new FlatContext instance instead.
+// If immutability is required, call Fork first and add the value to the fork, or
+// call Freeze to make c panic on further WithValue calls.
 func (c *FlatContext) WithValue(key, value interface{}) *FlatContext {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if c.frozen {
+		panic("fc: WithValue called on a frozen FlatContext")
+	}
 	c.values[key] = value
 	return c
 }
+
+// Fork returns a new FlatContext with a shallow copy of c's local values,
+// derived from c itself, so that WithValue calls on the fork do not mutate
+// c's map, which plain WithValue cannot do since it mutates its receiver in
+// place. Two forks of c do not see each other's values. Values added to c
+// after the fork are still visible through the fork, since a lookup that
+// misses the fork's own map falls through to c.
+//
+// The fork's parent is c, not c's own parent: Done, Err, and Deadline are
+// dispatched through c's methods, so a fork observes whatever cancellation
+// state c owns (from WithCancel, WithDeadline, WithCancelCause,
+// WithDeadlineCause, Merge, or WithParents) exactly as c does.
+func (c *FlatContext) Fork() *FlatContext {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	values := make(map[interface{}]interface{}, len(c.values))
+	for k, v := range c.values {
+		values[k] = v
+	}
+	return &FlatContext{
+		parent: c,
+		values: values,
+	}
+}
+
+// Freeze makes c immutable: any further call to WithValue on c panics. This
+// gives library authors a way to hand out a FlatContext to untrusted callees
+// without risking it being mutated out from under them. Freeze does not
+// affect Fork; a frozen context can still be forked, and the fork starts out
+// unfrozen.
+func (c *FlatContext) Freeze() {
+	c.mu.Lock()
+	c.frozen = true
+	c.mu.Unlock()
+}
+
+// Range calls f for each key-value pair in c's local values map, in no
+// particular order, stopping early if f returns false. It does not walk the
+// parent chain; use Value to look up a single key including parents.
+//
+// f is called while c's read lock is held: it must not call back into c
+// (WithValue, Freeze, WithContext, Value, Keys, another Range, ...), or the
+// calling goroutine can deadlock: the write-locking methods always do, and
+// the read-locking ones do once another goroutine is waiting to write.
+func (c *FlatContext) Range(f func(key, value interface{}) bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for k, v := range c.values {
+		if !f(k, v) {
+			return
+		}
+	}
+}
+
+// Keys returns the keys present in c's local values map, in no particular
+// order. Like Range, it does not walk the parent chain.
+func (c *FlatContext) Keys() []interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	keys := make([]interface{}, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// newChild returns a fresh FlatContext whose parent is c. It carries no
+// values of its own; lookups miss through to c via Value's parent fallback.
+func (c *FlatContext) newChild() *FlatContext {
+	return &FlatContext{
+		parent: c,
+		values: make(map[interface{}]interface{}),
+	}
+}
+
+// WithCancel returns a child FlatContext along with a CancelFunc that cancels
+// it. The child's Done channel is closed either when cancel is called or when
+// c is done, whichever happens first.
+func (c *FlatContext) WithCancel() (*FlatContext, CancelFunc) {
+	child := c.newChild()
+	child.done = make(chan struct{})
+	child.propagateCancel(c)
+	return child, func() { child.cancel(nil, context.Canceled) }
+}
+
+// WithCancelCause behaves like WithCancel but returns a CancelCauseFunc
+// instead of a CancelFunc. Calling cancel with a non-nil error (the "cause")
+// records it; it can be retrieved by calling Cause on the returned
+// FlatContext or any of its descendants.
+func (c *FlatContext) WithCancelCause() (*FlatContext, CancelCauseFunc) {
+	child := c.newChild()
+	child.done = make(chan struct{})
+	child.propagateCancel(c)
+	return child, func(cause error) { child.cancel(cause, context.Canceled) }
+}
+
+// WithDeadline returns a child FlatContext whose Done channel is closed when
+// the deadline expires, when cancel is called, or when c is done, whichever
+// happens first.
+func (c *FlatContext) WithDeadline(d time.Time) (*FlatContext, CancelFunc) {
+	return c.withDeadline(d, nil)
+}
+
+// WithDeadlineCause behaves like WithDeadline but also sets the cause of the
+// returned FlatContext's Done channel closing when the deadline is exceeded.
+// The cause is only used if the deadline expires before cancel is called.
+func (c *FlatContext) WithDeadlineCause(d time.Time, cause error) (*FlatContext, CancelFunc) {
+	return c.withDeadline(d, cause)
+}
+
+// WithTimeout is shorthand for WithDeadline(time.Now().Add(timeout)).
+func (c *FlatContext) WithTimeout(timeout time.Duration) (*FlatContext, CancelFunc) {
+	return c.withDeadline(time.Now().Add(timeout), nil)
+}
+
+func (c *FlatContext) withDeadline(d time.Time, cause error) (*FlatContext, CancelFunc) {
+	child := c.newChild()
+	child.done = make(chan struct{})
+	child.deadline = d
+	child.hasDeadline = true
+	child.propagateCancel(c)
+
+	if dur := time.Until(d); dur <= 0 {
+		child.cancel(deadlineCause(cause), context.DeadlineExceeded)
+	} else {
+		child.mu.Lock()
+		if child.err == nil {
+			child.timer = time.AfterFunc(dur, func() {
+				child.cancel(deadlineCause(cause), context.DeadlineExceeded)
+			})
+		}
+		child.mu.Unlock()
+	}
+	return child, func() { child.cancel(nil, context.Canceled) }
+}
+
+func deadlineCause(cause error) error {
+	if cause != nil {
+		return cause
+	}
+	return context.DeadlineExceeded
+}
+
+// propagateCancel arranges for child to be canceled when parent is done. If
+// parent can never be done (its Done method returns nil), no goroutine is
+// started.
+func (child *FlatContext) propagateCancel(parent context.Context) {
+	parentDone := parent.Done()
+	if parentDone == nil {
+		return
+	}
+	select {
+	case <-parentDone:
+		child.cancel(causeOf(parent), parent.Err())
+		return
+	default:
+	}
+	go func() {
+		select {
+		case <-parentDone:
+			child.cancel(causeOf(parent), parent.Err())
+		case <-child.done:
+		}
+	}()
+}
+
+// cancel closes child.done and records err (and cause, if non-nil) unless
+// child was already canceled. It stops child's deadline timer, if any, so
+// that timers never leak past cancellation.
+func (child *FlatContext) cancel(cause, err error) {
+	if err == nil {
+		err = context.Canceled
+	}
+	child.mu.Lock()
+	if child.err != nil {
+		child.mu.Unlock()
+		return // already canceled
+	}
+	child.err = err
+	if cause != nil {
+		child.cause = cause
+	} else {
+		child.cause = err
+	}
+	if child.done == nil {
+		child.done = make(chan struct{})
+	}
+	close(child.done)
+	if child.timer != nil {
+		child.timer.Stop()
+	}
+	child.mu.Unlock()
+}
+
+// causeOf returns the cancellation cause of ctx: Cause(ctx) if ctx is a
+// FlatContext, or context.Cause(ctx) otherwise.
+func causeOf(ctx context.Context) error {
+	if fc, ok := ctx.(*FlatContext); ok {
+		return Cause(fc)
+	}
+	return context.Cause(ctx)
+}
+
+// Cause returns the non-nil error explaining why c was canceled. It mirrors
+// context.Cause: if c (or the nearest FlatContext ancestor that owns its
+// cancellation) was canceled with an explicit cause via WithCancelCause or
+// WithDeadlineCause, that cause is returned. Otherwise it falls back to
+// c.Err(), which may itself delegate to a non-FlatContext parent's
+// context.Cause. Cause returns nil if c has not been canceled.
+func Cause(c *FlatContext) error {
+	for cur := c; cur != nil; {
+		cur.mu.RLock()
+		owned := cur.done != nil
+		cause, err, parent := cur.cause, cur.err, cur.parent
+		cur.mu.RUnlock()
+		if owned {
+			if cause != nil {
+				return cause
+			}
+			return err
+		}
+		if fc, ok := parent.(*FlatContext); ok {
+			cur = fc
+			continue
+		}
+		if cause := context.Cause(parent); cause != nil {
+			return cause
+		}
+		return parent.Err()
+	}
+	return nil
+}
+
+// AfterFunc arranges for f to be called in its own goroutine after c is
+// done, either because a parent finished or because c was itself canceled.
+// If c is already done, f is started immediately in its own goroutine.
+//
+// The returned stop function deregisters the callback. It returns true if
+// it stopped f from being run; it returns false if f has already run or has
+// already been stopped.
+//
+// Because WithContext can swap c's parent at any time, AfterFunc re-arms a
+// single watcher goroutine per FlatContext whenever the parent changes, so
+// callbacks registered before a swap fire based on whichever parent (old or
+// new) finishes first from the watcher's point of view at that time.
+func (c *FlatContext) AfterFunc(f func()) (stop func() bool) {
+	c.afterMu.Lock()
+	if c.afterFuncs == nil {
+		c.afterFuncs = make(map[uint64]func())
+	}
+	c.afterSeq++
+	id := c.afterSeq
+	c.afterFuncs[id] = f
+	if c.swapSignal == nil {
+		c.swapSignal = make(chan struct{})
+	}
+	if !c.watching {
+		c.watching = true
+		go c.watchAfterFuncs()
+	}
+	c.afterMu.Unlock()
+
+	return func() bool {
+		c.afterMu.Lock()
+		if _, ok := c.afterFuncs[id]; !ok {
+			c.afterMu.Unlock()
+			return false
+		}
+		delete(c.afterFuncs, id)
+		var wake chan struct{}
+		if len(c.afterFuncs) == 0 && c.swapSignal != nil {
+			// Wake the watcher so it can notice there is nothing left to
+			// wait for and exit, instead of blocking until c is done.
+			wake = c.swapSignal
+			c.swapSignal = make(chan struct{})
+		}
+		c.afterMu.Unlock()
+		if wake != nil {
+			close(wake)
+		}
+		return true
+	}
+}
+
+// watchAfterFuncs waits for c to become done, re-reading c.Done() whenever
+// WithContext or WithParents swaps the parent out from under it, then fires every
+// registered AfterFunc callback in its own goroutine. It exits without
+// firing anything once stop has removed every registered callback.
+func (c *FlatContext) watchAfterFuncs() {
+	for {
+		c.afterMu.Lock()
+		if len(c.afterFuncs) == 0 {
+			c.watching = false
+			c.afterMu.Unlock()
+			return
+		}
+		swap := c.swapSignal
+		c.afterMu.Unlock()
+
+		done := c.Done()
+		if done == nil {
+			// The current parent never finishes; wait for the parent to
+			// change and re-check.
+			<-swap
+			continue
+		}
+		select {
+		case <-done:
+			c.fireAfterFuncs()
+			return
+		case <-swap:
+			continue
+		}
+	}
+}
+
+func (c *FlatContext) fireAfterFuncs() {
+	c.afterMu.Lock()
+	fns := c.afterFuncs
+	c.afterFuncs = nil
+	c.watching = false
+	c.afterMu.Unlock()
+
+	for _, f := range fns {
+		go f()
+	}
+}
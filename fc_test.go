@@ -2,6 +2,7 @@ package fc
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"testing"
 	"time"
@@ -182,17 +183,21 @@ func TestConcurrency(t *testing.T) {
 	wg.Wait()
 }
 
-// TestImmutableBehavior tests that WithValue does not modify the original context.
+// TestImmutableBehavior tests that a forked context can diverge via
+// WithValue without either side leaking values into the other. WithValue
+// itself is documented to mutate its receiver in place; Fork is the way to
+// get two independently-evolving contexts.
 func TestImmutableBehavior(t *testing.T) {
 	fc := Background()
 	fc.WithValue("key", "value")
 
-	// Create a new context with an additional value
-	newFc := fc.WithValue("new_key", "new_value")
+	// Fork before diverging, so that WithValue on the fork does not modify fc.
+	newFc := fc.Fork()
+	newFc.WithValue("new_key", "new_value")
 
 	// Ensure the original context is unchanged
 	if val := fc.Value("new_key"); val != nil {
-		t.Error("WithValue() modified the original context")
+		t.Error("Fork() did not isolate the original from values added to the fork")
 	}
 
 	// Ensure the new context has both values
@@ -203,3 +208,618 @@ func TestImmutableBehavior(t *testing.T) {
 		t.Error("New context did not store the new value")
 	}
 }
+
+// TestFreeze tests that WithValue panics once a FlatContext has been frozen.
+func TestFreeze(t *testing.T) {
+	fc := Background()
+	fc.WithValue("key", "value")
+	fc.Freeze()
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("WithValue should panic on a frozen FlatContext")
+		}
+	}()
+	fc.WithValue("key2", "value2")
+}
+
+// TestForkAfterFreeze tests that Freeze only applies to the original
+// context; a fork of a frozen context starts out unfrozen.
+func TestForkAfterFreeze(t *testing.T) {
+	fc := Background()
+	fc.WithValue("key", "value")
+	fc.Freeze()
+
+	forked := fc.Fork()
+	forked.WithValue("key2", "value2") // must not panic
+
+	if val := forked.Value("key2"); val != "value2" {
+		t.Error("fork of a frozen context should accept new values")
+	}
+}
+
+// TestForkObservesCancelSource tests that a fork of a WithCancel-derived
+// context is still canceled when the source it was forked from is canceled.
+func TestForkObservesCancelSource(t *testing.T) {
+	child, cancel := Background().WithCancel()
+	fork := child.Fork()
+
+	cancel()
+
+	select {
+	case <-fork.Done():
+	case <-time.After(time.Second):
+		t.Fatal("fork should observe cancellation of the FlatContext it was forked from")
+	}
+	if err := fork.Err(); err != context.Canceled {
+		t.Errorf("Err() = %v, want context.Canceled", err)
+	}
+}
+
+// TestForkOfMerge tests that forking a Merge result does not panic and
+// still observes the merge's cancellation.
+func TestForkOfMerge(t *testing.T) {
+	p1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+
+	m := Merge(p1, context.Background())
+	fork := m.Fork()
+
+	select {
+	case <-fork.Done():
+		t.Fatal("fork of a merge should not be done before a parent is done")
+	default:
+	}
+
+	cancel1()
+
+	select {
+	case <-fork.Done():
+	case <-time.After(time.Second):
+		t.Fatal("fork of a merge did not observe parent cancellation")
+	}
+	if err := fork.Err(); err != context.Canceled {
+		t.Errorf("Err() = %v, want context.Canceled", err)
+	}
+}
+
+// TestFlatWithCancel tests that WithCancel produces a child whose Done
+// channel closes exactly when its CancelFunc is called.
+func TestFlatWithCancel(t *testing.T) {
+	parent := Background()
+	child, cancel := parent.WithCancel()
+
+	select {
+	case <-child.Done():
+		t.Fatal("child should not be done before cancel is called")
+	default:
+	}
+
+	cancel()
+
+	select {
+	case <-child.Done():
+	case <-time.After(time.Second):
+		t.Fatal("child Done() channel was not closed after cancel")
+	}
+	if err := child.Err(); err != context.Canceled {
+		t.Errorf("Err() = %v, want context.Canceled", err)
+	}
+
+	// Calling cancel again must be a no-op.
+	cancel()
+}
+
+// TestFlatWithCancelParentPropagation tests that canceling the parent also
+// cancels a child produced by WithCancel.
+func TestFlatWithCancelParentPropagation(t *testing.T) {
+	parent, parentCancel := Background().WithCancel()
+	child, cancel := parent.WithCancel()
+	defer cancel()
+
+	parentCancel()
+
+	select {
+	case <-child.Done():
+	case <-time.After(time.Second):
+		t.Fatal("child was not canceled when parent was canceled")
+	}
+	if err := child.Err(); err != context.Canceled {
+		t.Errorf("Err() = %v, want context.Canceled", err)
+	}
+}
+
+// TestFlatWithCancelCause tests that WithCancelCause records the cause
+// passed to its CancelCauseFunc and that Cause retrieves it.
+func TestFlatWithCancelCause(t *testing.T) {
+	myErr := errors.New("custom cancel cause")
+	child, cancel := Background().WithCancelCause()
+	cancel(myErr)
+
+	if err := child.Err(); err != context.Canceled {
+		t.Errorf("Err() = %v, want context.Canceled", err)
+	}
+	if cause := Cause(child); cause != myErr {
+		t.Errorf("Cause() = %v, want %v", cause, myErr)
+	}
+}
+
+// TestFlatWithDeadline tests that WithDeadline cancels its child with
+// context.DeadlineExceeded once the deadline passes.
+func TestFlatWithDeadline(t *testing.T) {
+	child, cancel := Background().WithDeadline(time.Now().Add(10 * time.Millisecond))
+	defer cancel()
+
+	select {
+	case <-child.Done():
+	case <-time.After(time.Second):
+		t.Fatal("child was not canceled after its deadline")
+	}
+	if err := child.Err(); err != context.DeadlineExceeded {
+		t.Errorf("Err() = %v, want context.DeadlineExceeded", err)
+	}
+	deadline, ok := child.Deadline()
+	if !ok || deadline.IsZero() {
+		t.Error("Deadline() should report the deadline that was set")
+	}
+}
+
+// TestFlatWithTimeout tests that WithTimeout behaves like WithDeadline
+// relative to time.Now().
+func TestFlatWithTimeout(t *testing.T) {
+	child, cancel := Background().WithTimeout(10 * time.Millisecond)
+	defer cancel()
+
+	select {
+	case <-child.Done():
+	case <-time.After(time.Second):
+		t.Fatal("child was not canceled after its timeout")
+	}
+	if err := child.Err(); err != context.DeadlineExceeded {
+		t.Errorf("Err() = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+// TestFlatWithDeadlineCause tests that WithDeadlineCause's cause is only
+// surfaced once the deadline actually expires.
+func TestFlatWithDeadlineCause(t *testing.T) {
+	myErr := errors.New("custom deadline cause")
+	child, cancel := Background().WithDeadlineCause(time.Now().Add(10*time.Millisecond), myErr)
+	defer cancel()
+
+	<-child.Done()
+	if cause := Cause(child); cause != myErr {
+		t.Errorf("Cause() = %v, want %v", cause, myErr)
+	}
+}
+
+// TestCauseFallsBackToErr tests that Cause falls back to Err() when no
+// explicit cause was ever recorded.
+func TestCauseFallsBackToErr(t *testing.T) {
+	child, cancel := Background().WithCancel()
+	cancel()
+
+	if cause := Cause(child); cause != context.Canceled {
+		t.Errorf("Cause() = %v, want context.Canceled", cause)
+	}
+}
+
+// TestAfterFunc tests that a callback registered with AfterFunc runs after
+// the context is done.
+func TestAfterFunc(t *testing.T) {
+	child, cancel := Background().WithCancel()
+
+	done := make(chan struct{})
+	child.AfterFunc(func() { close(done) })
+
+	select {
+	case <-done:
+		t.Fatal("AfterFunc callback ran before the context was done")
+	default:
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("AfterFunc callback did not run after cancellation")
+	}
+}
+
+// TestAfterFuncAlreadyDone tests that AfterFunc runs its callback promptly
+// when registered on an already-done context.
+func TestAfterFuncAlreadyDone(t *testing.T) {
+	child, cancel := Background().WithCancel()
+	cancel()
+
+	done := make(chan struct{})
+	child.AfterFunc(func() { close(done) })
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("AfterFunc callback did not run for an already-done context")
+	}
+}
+
+// TestAfterFuncStop tests that stop prevents a pending callback from running
+// and reports whether it did so.
+func TestAfterFuncStop(t *testing.T) {
+	child, cancel := Background().WithCancel()
+
+	ran := make(chan struct{})
+	stop := child.AfterFunc(func() { close(ran) })
+
+	if stopped := stop(); !stopped {
+		t.Error("stop() should report true when the callback had not yet run")
+	}
+
+	cancel()
+
+	select {
+	case <-ran:
+		t.Fatal("AfterFunc callback ran after being stopped")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if stopped := stop(); stopped {
+		t.Error("stop() should report false when called a second time")
+	}
+}
+
+// TestAfterFuncStopWakesWatcher tests that stopping the last registered
+// callback lets the watcher goroutine exit immediately instead of blocking
+// until the context is done, which would leak it for the context's lifetime.
+func TestAfterFuncStopWakesWatcher(t *testing.T) {
+	child := Background() // parent (context.Background()) is never done
+
+	stop := child.AfterFunc(func() {})
+	stop()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		child.afterMu.Lock()
+		watching := child.watching
+		child.afterMu.Unlock()
+		if !watching {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("watcher goroutine did not exit after its last callback was stopped")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestAfterFuncParentSwap tests that a callback registered before a
+// WithContext swap still fires once the new parent finishes.
+func TestAfterFuncParentSwap(t *testing.T) {
+	child := Background()
+
+	done := make(chan struct{})
+	child.AfterFunc(func() { close(done) })
+
+	newParent, newCancel := context.WithCancel(context.Background())
+	child.WithContext(newParent)
+
+	select {
+	case <-done:
+		t.Fatal("AfterFunc callback fired before the new parent was done")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	newCancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("AfterFunc callback did not fire after the new parent finished")
+	}
+}
+
+// TestMergeDone tests that a merged FlatContext is done as soon as any one
+// of its parents finishes.
+func TestMergeDone(t *testing.T) {
+	p1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	p2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+
+	m := Merge(p1, p2)
+
+	select {
+	case <-m.Done():
+		t.Fatal("merged context should not be done before any parent is done")
+	default:
+	}
+
+	cancel2()
+
+	select {
+	case <-m.Done():
+	case <-time.After(time.Second):
+		t.Fatal("merged context was not done after a parent was canceled")
+	}
+	if err := m.Err(); err != context.Canceled {
+		t.Errorf("Err() = %v, want context.Canceled", err)
+	}
+}
+
+// TestMergeAlreadyDoneParent tests that Merge closes immediately when one of
+// the parents is already done.
+func TestMergeAlreadyDoneParent(t *testing.T) {
+	p1, cancel1 := context.WithCancel(context.Background())
+	cancel1()
+
+	m := Merge(p1, context.Background())
+
+	select {
+	case <-m.Done():
+	case <-time.After(time.Second):
+		t.Fatal("merged context should already be done when a parent is already done")
+	}
+}
+
+// TestMergeNoParents tests that Merge() called with zero parents (a legal
+// variadic call) does not panic on Value or Deadline.
+func TestMergeNoParents(t *testing.T) {
+	m := Merge()
+
+	if val := m.Value("x"); val != nil {
+		t.Errorf("Value(x) = %v, want nil", val)
+	}
+	if _, ok := m.Deadline(); ok {
+		t.Error("Deadline() should report no deadline")
+	}
+	select {
+	case <-m.Done():
+		t.Fatal("a merge of zero parents should never be done")
+	default:
+	}
+}
+
+// TestWithParentsNoParents tests that WithParents() called with zero
+// arguments does not panic on Value or Deadline.
+func TestWithParentsNoParents(t *testing.T) {
+	fc := Background()
+	fc.WithParents()
+
+	if val := fc.Value("x"); val != nil {
+		t.Errorf("Value(x) = %v, want nil", val)
+	}
+	if _, ok := fc.Deadline(); ok {
+		t.Error("Deadline() should report no deadline")
+	}
+}
+
+// TestMergeValue tests that Value scans each parent in order after a local
+// map miss.
+func TestMergeValue(t *testing.T) {
+	p1 := context.WithValue(context.Background(), "key1", "from-p1")
+	p2 := context.WithValue(context.Background(), "key2", "from-p2")
+
+	m := Merge(p1, p2)
+	m.WithValue("key1", "local")
+
+	if val := m.Value("key1"); val != "local" {
+		t.Errorf("Value(key1) = %v, want local value to win", val)
+	}
+	if val := m.Value("key2"); val != "from-p2" {
+		t.Errorf("Value(key2) = %v, want from-p2", val)
+	}
+	if val := m.Value("missing"); val != nil {
+		t.Errorf("Value(missing) = %v, want nil", val)
+	}
+}
+
+// TestMergeDeadline tests that Deadline reports the earliest deadline among
+// the parents.
+func TestMergeDeadline(t *testing.T) {
+	near, cancelNear := context.WithTimeout(context.Background(), time.Minute)
+	defer cancelNear()
+	far, cancelFar := context.WithTimeout(context.Background(), time.Hour)
+	defer cancelFar()
+
+	m := Merge(far, near, context.Background())
+
+	deadline, ok := m.Deadline()
+	if !ok {
+		t.Fatal("Deadline() should report a deadline when a parent has one")
+	}
+	wantDeadline, _ := near.Deadline()
+	if !deadline.Equal(wantDeadline) {
+		t.Errorf("Deadline() = %v, want the earliest parent deadline %v", deadline, wantDeadline)
+	}
+}
+
+// TestWithParents tests that WithParents turns an existing FlatContext into
+// a merge of the given parents.
+func TestWithParents(t *testing.T) {
+	fc := Background()
+	p1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+
+	fc.WithParents(p1)
+
+	select {
+	case <-fc.Done():
+		t.Fatal("should not be done before p1 is canceled")
+	default:
+	}
+
+	cancel1()
+
+	select {
+	case <-fc.Done():
+	case <-time.After(time.Second):
+		t.Fatal("WithParents did not wire up cancellation from p1")
+	}
+}
+
+// TestWithParentsRetiresStaleParent tests that replacing the parent set via
+// a second WithParents call detaches the previous parents: canceling one
+// afterward must not cancel the FlatContext.
+func TestWithParentsRetiresStaleParent(t *testing.T) {
+	fc := Background()
+	p1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	p2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+
+	fc.WithParents(p1)
+	fc.WithParents(p2)
+
+	cancel1()
+
+	select {
+	case <-fc.Done():
+		t.Fatal("canceling a detached parent (p1) should not cancel fc")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel2()
+
+	select {
+	case <-fc.Done():
+	case <-time.After(time.Second):
+		t.Fatal("canceling the current parent (p2) should still cancel fc")
+	}
+}
+
+// TestWithContextClearsParents tests that WithContext is mutually exclusive
+// with WithParents: it clears the parent set installed by WithParents, so
+// Value/Deadline consult the new single parent instead of the old set.
+func TestWithContextClearsParents(t *testing.T) {
+	fc := Background()
+	mergeParent := context.WithValue(context.Background(), "from-merge", "m")
+	fc.WithParents(mergeParent)
+
+	newParent := context.WithValue(context.Background(), "from-new", "n")
+	fc.WithContext(newParent)
+
+	if val := fc.Value("from-new"); val != "n" {
+		t.Errorf("Value(from-new) = %v, want n; WithContext should take effect after WithParents", val)
+	}
+	if val := fc.Value("from-merge"); val != nil {
+		t.Errorf("Value(from-merge) = %v, want nil; WithContext should clear the old parent set", val)
+	}
+
+	deadlineParent, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	fc.WithContext(deadlineParent)
+	if _, ok := fc.Deadline(); !ok {
+		t.Error("Deadline() should report the new single parent's deadline after WithContext")
+	}
+}
+
+// TestDetachPreservesValues tests that Detach carries forward values from
+// both the source's own map and its parent chain.
+func TestDetachPreservesValues(t *testing.T) {
+	parent := context.WithValue(context.Background(), "from-parent", "p")
+	source := New(parent)
+	source.WithValue("from-source", "s")
+
+	detached := Detach(source)
+
+	if val := detached.Value("from-source"); val != "s" {
+		t.Errorf("Value(from-source) = %v, want s", val)
+	}
+	if val := detached.Value("from-parent"); val != "p" {
+		t.Errorf("Value(from-parent) = %v, want p", val)
+	}
+}
+
+// TestDetachStripsCancellation tests that Detach's result is never done,
+// even if the source is canceled.
+func TestDetachStripsCancellation(t *testing.T) {
+	source, cancel := Background().WithCancel()
+	detached := Detach(source)
+
+	cancel()
+
+	select {
+	case <-detached.Done():
+		t.Fatal("Detach()'d context should never be done")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if detached.Done() != nil {
+		t.Error("Detach()'d context's Done() should return nil")
+	}
+	if err := detached.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil", err)
+	}
+	if _, ok := detached.Deadline(); ok {
+		t.Error("Deadline() should report no deadline")
+	}
+}
+
+// TestConcurrentParentSwap swaps the parent concurrently with reads that
+// touch it (Value, Done, Err, Deadline), exercising the locking added to
+// WithContext. Run with -race to catch a regression.
+func TestConcurrentParentSwap(t *testing.T) {
+	fc := Background()
+	var wg sync.WaitGroup
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx := context.WithValue(context.Background(), "k", i)
+			fc.WithContext(ctx)
+		}(i)
+	}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fc.Value("k")
+			fc.Done()
+			fc.Err()
+			fc.Deadline()
+		}()
+	}
+	wg.Wait()
+}
+
+// TestKeysAndRange tests that Keys and Range report exactly the local
+// values map, not anything reachable through the parent chain.
+func TestKeysAndRange(t *testing.T) {
+	parent := context.WithValue(context.Background(), "parent-key", "p")
+	fc := New(parent)
+	fc.WithValue("a", 1)
+	fc.WithValue("b", 2)
+
+	keys := fc.Keys()
+	if len(keys) != 2 {
+		t.Fatalf("Keys() = %v, want 2 entries", keys)
+	}
+	seen := map[interface{}]bool{}
+	for _, k := range keys {
+		seen[k] = true
+	}
+	if !seen["a"] || !seen["b"] {
+		t.Errorf("Keys() = %v, want a and b", keys)
+	}
+	if seen["parent-key"] {
+		t.Error("Keys() should not include values from the parent chain")
+	}
+
+	collected := map[interface{}]interface{}{}
+	fc.Range(func(k, v interface{}) bool {
+		collected[k] = v
+		return true
+	})
+	if collected["a"] != 1 || collected["b"] != 2 {
+		t.Errorf("Range() collected %v, want a:1 b:2", collected)
+	}
+
+	var visited int
+	fc.Range(func(k, v interface{}) bool {
+		visited++
+		return false
+	})
+	if visited != 1 {
+		t.Errorf("Range() should stop early when f returns false, visited %d times", visited)
+	}
+}